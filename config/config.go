@@ -0,0 +1,36 @@
+// Package config holds katafygio's runtime configuration, populated from
+// command line flags and their environment variable equivalents.
+package config
+
+import (
+	"time"
+)
+
+// KfConfig holds the configuration shared by the controllers and the CRD
+// discovery subsystem.
+type KfConfig struct {
+	// ResyncIntv is how often each controller does a full resync against
+	// the apiserver, on top of the watch-driven updates.
+	ResyncIntv time.Duration
+
+	// Workers is how many goroutines process each controller's workqueue.
+	// Defaults to 1 when unset or non-positive.
+	Workers int
+
+	// Include, when non-empty, restricts CRD discovery to only the listed
+	// Kinds (case-insensitive). Exclude always wins over Include.
+	Include []string
+
+	// Exclude drops the listed Kinds (case-insensitive) from CRD discovery,
+	// even if they're also listed in Include.
+	Exclude []string
+
+	// KeepOwned lists the Kinds that should be backed up even when they
+	// carry a controller owner-reference to a known parent Kind (eg. keep
+	// ReplicaSets despite them normally being owned by a Deployment).
+	KeepOwned []string
+
+	// DisableOwnerFilter turns off owner-reference based filtering
+	// entirely, backing up every Kind regardless of ownership.
+	DisableOwnerFilter bool
+}
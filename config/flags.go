@@ -0,0 +1,113 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// New builds a KfConfig from command line flags, falling back to the
+// matching KF_* environment variable when a flag isn't set explicitly on
+// the command line.
+func New(args []string) (*KfConfig, error) {
+	fs := flag.NewFlagSet("katafygio", flag.ContinueOnError)
+	conf := &KfConfig{}
+
+	fs.DurationVar(&conf.ResyncIntv, "resync-interval",
+		envDuration("KF_RESYNC_INTERVAL", 5*time.Minute),
+		"Full resync interval, on top of the watch-driven updates")
+
+	fs.IntVar(&conf.Workers, "workers",
+		envInt("KF_WORKERS", 1),
+		"Number of workers processing each controller's workqueue")
+
+	fs.Var(newStringSliceValue(&conf.Include, envStringSlice("KF_INCLUDE", nil)),
+		"include", "Comma-separated list of Kinds to back up; all Kinds when unset")
+
+	fs.Var(newStringSliceValue(&conf.Exclude, envStringSlice("KF_EXCLUDE", nil)),
+		"exclude", "Comma-separated list of Kinds to never back up")
+
+	fs.Var(newStringSliceValue(&conf.KeepOwned, envStringSlice("KF_KEEP_OWNED", nil)),
+		"keep-owned", "Comma-separated list of Kinds to back up even if owned by a known parent Kind")
+
+	fs.BoolVar(&conf.DisableOwnerFilter, "disable-owner-filter",
+		envBool("KF_DISABLE_OWNER_FILTER", false),
+		"Back up every Kind regardless of controller owner-references")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// stringSliceValue adapts a comma-separated flag (and its matching KF_*
+// environment variable) to the flag.Value interface, since the stdlib flag
+// package has no built-in support for multi-value flags.
+type stringSliceValue struct {
+	dest *[]string
+}
+
+func newStringSliceValue(dest *[]string, def []string) *stringSliceValue {
+	*dest = def
+	return &stringSliceValue{dest: dest}
+}
+
+func (s *stringSliceValue) String() string {
+	if s == nil || s.dest == nil {
+		return ""
+	}
+	return strings.Join(*s.dest, ",")
+}
+
+func (s *stringSliceValue) Set(v string) error {
+	*s.dest = splitAndTrim(v)
+	return nil
+}
+
+func envStringSlice(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	return splitAndTrim(v)
+}
+
+func splitAndTrim(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+func envInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func envBool(key string, def bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
@@ -6,21 +6,30 @@
 package controller
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/bpineau/katafygio/config"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
 
 	"github.com/ghodss/yaml"
 )
 
-const maxProcessRetry = 6
+const (
+	maxProcessRetry = 6
+
+	// defaultWorkers is used when KfConfig doesn't specify a worker count.
+	defaultWorkers = 1
+)
 
 // Action represents the kind of object change we're notifying
 type Action int
@@ -43,18 +52,32 @@ type Event struct {
 
 // Controller is a generic kubernetes controller
 type Controller struct {
-	stopCh   chan struct{}
-	doneCh   chan struct{}
+	// cancel defaults to a no-op in New, so Stop is always safe to call
+	// even on a controller that was never Start()ed (eg. an error path).
+	cancel   context.CancelFunc
+	workers  int
+	wg       sync.WaitGroup
 	evchan   chan Event
 	name     string
 	config   *config.KfConfig
-	queue    workqueue.RateLimitingInterface
+	queue    workqueue.TypedRateLimitingInterface[cache.ObjectName]
 	informer cache.SharedIndexInformer
+	pruner   Pruner
+	owned    OwnerFilter
+
+	pendingMu sync.Mutex
+	pending   map[cache.ObjectName]bool
+
+	skippedMu sync.Mutex
+	skipped   map[cache.ObjectName]bool
 }
 
 // New return an untyped, generic Kubernetes controller
-func New(lw cache.ListerWatcher, evchan chan Event, name string, config *config.KfConfig) *Controller {
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+func New(ctx context.Context, lw cache.ListerWatcher, evchan chan Event, name string, config *config.KfConfig) *Controller {
+	logger := klog.FromContext(ctx).WithValues("kind", name)
+	logger.V(4).Info("Creating controller")
+
+	queue := workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[cache.ObjectName]())
 
 	informer := cache.NewSharedIndexInformer(
 		lw,
@@ -63,93 +86,168 @@ func New(lw cache.ListerWatcher, evchan chan Event, name string, config *config.
 		cache.Indexers{},
 	)
 
+	workers := config.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+
+	c := &Controller{
+		cancel:   func() {},
+		workers:  workers,
+		evchan:   evchan,
+		name:     name,
+		config:   config,
+		queue:    queue,
+		informer: informer,
+		pruner:   NewSchemaPruner(nil),
+		owned:    newDefaultOwnerFilter(name, config),
+		pending:  make(map[cache.ObjectName]bool),
+		skipped:  make(map[cache.ObjectName]bool),
+	}
+
 	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			key, err := cache.MetaNamespaceKeyFunc(obj)
-			if err == nil {
-				queue.Add(key)
+			accessor, ok := obj.(metav1.Object)
+			if !ok {
+				utilruntime.HandleError(fmt.Errorf("not a metav1.Object: %v", obj))
+				return
 			}
+			c.enqueueKey(cache.MetaObjectToName(accessor))
 		},
 		UpdateFunc: func(old, new interface{}) {
-			key, err := cache.MetaNamespaceKeyFunc(new)
-			if err == nil {
-				queue.Add(key)
+			accessor, ok := new.(metav1.Object)
+			if !ok {
+				utilruntime.HandleError(fmt.Errorf("not a metav1.Object: %v", new))
+				return
 			}
+			c.enqueueKey(cache.MetaObjectToName(accessor))
 		},
 		DeleteFunc: func(obj interface{}) {
-			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+			objName, err := cache.DeletionHandlingObjectToName(obj)
 			if err == nil {
-				queue.Add(key)
+				c.enqueueKey(objName)
 			}
 		},
 	})
 
-	return &Controller{
-		stopCh:   make(chan struct{}),
-		doneCh:   make(chan struct{}),
-		evchan:   evchan,
-		name:     name,
-		config:   config,
-		queue:    queue,
-		informer: informer,
+	return c
+}
+
+// SetPruner overrides the Pruner used before an object is serialized, eg.
+// to register a Kind-specific prune rule or plug in a schema-aware one
+// backed by the cluster's discovered OpenAPI document.
+func (c *Controller) SetPruner(p Pruner) {
+	c.pruner = p
+}
+
+// SetOwnerFilter overrides the OwnerFilter used to drop generated child
+// objects (eg. ReplicaSets owned by a Deployment) before they're enqueued.
+func (c *Controller) SetOwnerFilter(f OwnerFilter) {
+	c.owned = f
+}
+
+// enqueueKey adds a key to the workqueue, tracking coalesced (already
+// pending) adds so they show up in the coalesced_updates metric.
+func (c *Controller) enqueueKey(objName cache.ObjectName) {
+	c.pendingMu.Lock()
+	if c.pending[objName] {
+		coalescedUpdates.WithLabelValues(c.name).Inc()
+	} else {
+		c.pending[objName] = true
 	}
+	c.pendingMu.Unlock()
+
+	c.queue.Add(objName)
+	queueDepth.WithLabelValues(c.name).Set(float64(c.queue.Len()))
 }
 
-// Start launchs the controller in the background
-func (c *Controller) Start() {
-	c.config.Logger.Infof("Starting %s controller", c.name)
+// Start launchs the controller in the background. The controller runs until
+// ctx is cancelled or Stop is called.
+func (c *Controller) Start(ctx context.Context) {
+	logger := klog.FromContext(ctx).WithValues("kind", c.name)
+	logger.Info("Starting controller")
 	defer utilruntime.HandleCrash()
 
-	go c.informer.Run(c.stopCh)
+	ctx, c.cancel = context.WithCancel(ctx)
 
-	if !cache.WaitForCacheSync(c.stopCh, c.informer.HasSynced) {
-		utilruntime.HandleError(fmt.Errorf("Timed out waiting for caches to sync"))
+	go c.informer.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced) {
+		utilruntime.HandleError(fmt.Errorf("timed out waiting for %s caches to sync", c.name))
 		return
 	}
 
-	go wait.Until(c.runWorker, time.Second, c.stopCh)
+	for i := 0; i < c.workers; i++ {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			wait.UntilWithContext(ctx, c.runWorker, time.Second)
+		}()
+	}
 }
 
-// Stop halts the controller
-func (c *Controller) Stop() {
-	close(c.stopCh)
+// Stop halts the controller, waiting for its workers to drain.
+func (c *Controller) Stop(ctx context.Context) {
+	c.cancel()
 	c.queue.ShutDown()
-	<-c.doneCh
-	c.config.Logger.Infof("Stopping %s controller", c.name)
+	c.wg.Wait()
+
+	// Drop this kind's time series: controllers spawned for a discovered
+	// CRD (see pkg/discovery) come and go with the CRD's lifecycle, and a
+	// stopped controller never reports again, so its series would
+	// otherwise linger in the registry forever.
+	queueDepth.DeleteLabelValues(c.name)
+	processLatency.DeleteLabelValues(c.name)
+	processRetries.DeleteLabelValues(c.name)
+	coalescedUpdates.DeleteLabelValues(c.name)
+
+	klog.FromContext(ctx).WithValues("kind", c.name).Info("Stopping controller")
 }
 
-func (c *Controller) runWorker() {
-	defer close(c.doneCh)
-	for c.processNextItem() {
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
 		// continue looping
 	}
 }
 
-func (c *Controller) processNextItem() bool {
-	key, quit := c.queue.Get()
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	objName, quit := c.queue.Get()
 	if quit {
 		return false
 	}
-	defer c.queue.Done(key)
+	defer c.queue.Done(objName)
 
-	err := c.processItem(key.(string))
+	c.pendingMu.Lock()
+	delete(c.pending, objName)
+	c.pendingMu.Unlock()
+
+	logger := klog.FromContext(ctx).WithValues("kind", c.name, "key", objName)
+
+	start := time.Now()
+	err := c.processItem(ctx, objName)
+	processLatency.WithLabelValues(c.name).Observe(time.Since(start).Seconds())
+	queueDepth.WithLabelValues(c.name).Set(float64(c.queue.Len()))
 
 	if err == nil {
 		// No error, reset the ratelimit counters
-		c.queue.Forget(key)
-	} else if c.queue.NumRequeues(key) < maxProcessRetry {
-		c.config.Logger.Errorf("Error processing %s (will retry): %v", key, err)
-		c.queue.AddRateLimited(key)
+		c.queue.Forget(objName)
+	} else if c.queue.NumRequeues(objName) < maxProcessRetry {
+		processRetries.WithLabelValues(c.name).Inc()
+		logger.Error(err, "error processing, will retry")
+		c.queue.AddRateLimited(objName)
 	} else {
 		// err != nil and too many retries
-		c.config.Logger.Errorf("Error processing %s (giving up): %v", key, err)
-		c.queue.Forget(key)
+		logger.Error(err, "error processing, giving up")
+		c.queue.Forget(objName)
 	}
 
 	return true
 }
 
-func (c *Controller) processItem(key string) error {
+func (c *Controller) processItem(ctx context.Context, objName cache.ObjectName) error {
+	logger := klog.FromContext(ctx).WithValues("kind", c.name, "key", objName)
+
+	key := objName.String()
 	rawobj, exists, err := c.informer.GetIndexer().GetByKey(key)
 
 	if err != nil {
@@ -157,23 +255,39 @@ func (c *Controller) processItem(key string) error {
 	}
 
 	if !exists {
-		// deleted object
+		// deleted object: if it was previously filtered out as a generated
+		// child, we never recorded it, so there's nothing to delete either
+		c.skippedMu.Lock()
+		wasSkipped := c.skipped[objName]
+		delete(c.skipped, objName)
+		c.skippedMu.Unlock()
+
+		if wasSkipped {
+			return nil
+		}
+
 		c.enqueue(Event{Action: Delete, Key: key, Kind: c.name, Obj: ""})
 		return nil
 	}
 
 	obj := rawobj.(*unstructured.Unstructured).DeepCopy()
 
-	// clear irrelevant attributes
-	uc := obj.UnstructuredContent()
-	md := uc["metadata"].(map[string]interface{})
-	delete(uc, "status")
-	delete(md, "selfLink")
-	delete(md, "uid")
-	delete(md, "resourceVersion")
-	delete(md, "generation")
+	if c.owned.Skip(obj) {
+		c.skippedMu.Lock()
+		c.skipped[objName] = true
+		c.skippedMu.Unlock()
+
+		logger.V(4).Info("Skipping object owned by a backed up parent", "owners", obj.GetOwnerReferences())
+		return nil
+	}
+
+	c.skippedMu.Lock()
+	delete(c.skipped, objName)
+	c.skippedMu.Unlock()
+
+	c.pruner.Prune(obj)
 
-	c.config.Logger.Debugf("Found %s/%s [%s]", obj.GetAPIVersion(), obj.GetKind(), key)
+	logger.V(4).Info("Found object", "apiVersion", obj.GetAPIVersion(), "objKind", obj.GetKind())
 
 	yml, err := yaml.Marshal(obj)
 	if err != nil {
@@ -0,0 +1,30 @@
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metrics expose per-controller workqueue and processing stats, so operators
+// can spot hot resource kinds and tune the per-controller worker count.
+var (
+	queueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "katafygio_controller_queue_depth",
+		Help: "Current number of items in a controller's workqueue.",
+	}, []string{"kind"})
+
+	processLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "katafygio_controller_process_duration_seconds",
+		Help: "Time spent processing a single item, per resource kind.",
+	}, []string{"kind"})
+
+	processRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "katafygio_controller_retries_total",
+		Help: "Number of item processing retries, per resource kind.",
+	}, []string{"kind"})
+
+	coalescedUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "katafygio_controller_coalesced_updates_total",
+		Help: "Number of queue adds that were coalesced into an already pending item, per resource kind.",
+	}, []string{"kind"})
+)
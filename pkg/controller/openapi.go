@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/openapi"
+	"k8s.io/client-go/openapi3"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+// refComponentPrefix is how a $ref points at a sibling definition within
+// the same OpenAPI v3 document's components.schemas, eg:
+//
+//	"$ref": "#/components/schemas/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"
+const refComponentPrefix = "#/components/schemas/"
+
+// gvkExtensionName is how an OpenAPI v3 schema definition records which
+// GVK(s) it describes (see k8s.io/kube-openapi's "extension-to-struct"
+// list), eg:
+//
+//	"x-kubernetes-group-version-kind": [{"group": "apps", "version": "v1", "kind": "Deployment"}]
+const gvkExtensionName = "x-kubernetes-group-version-kind"
+
+// DiscoveryOpenAPIGetter implements SchemaGetter by fetching the cluster's
+// OpenAPI v3 document one GroupVersion at a time, from the same discovery
+// client used to find served CRD versions, and caching the result so each
+// GroupVersion is only fetched once.
+type DiscoveryOpenAPIGetter struct {
+	root openapi3.Root
+
+	mu      sync.Mutex
+	byGVK   map[schema.GroupVersionKind]*spec.Schema
+	fetched map[schema.GroupVersion]error
+}
+
+// NewDiscoveryOpenAPIGetter returns a SchemaGetter backed by the given
+// discovery OpenAPI v3 client (typically `kubeClient.Discovery().OpenAPIV3()`).
+func NewDiscoveryOpenAPIGetter(client openapi.Client) *DiscoveryOpenAPIGetter {
+	return &DiscoveryOpenAPIGetter{
+		root:    openapi3.NewRoot(client),
+		byGVK:   make(map[schema.GroupVersionKind]*spec.Schema),
+		fetched: make(map[schema.GroupVersion]error),
+	}
+}
+
+// SchemaFor implements SchemaGetter.
+func (g *DiscoveryOpenAPIGetter) SchemaFor(gvk schema.GroupVersionKind) (*spec.Schema, error) {
+	gv := gvk.GroupVersion()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err, tried := g.fetched[gv]; tried {
+		return g.byGVK[gvk], err
+	}
+
+	err := g.loadLocked(gv)
+	g.fetched[gv] = err
+	return g.byGVK[gvk], err
+}
+
+// loadLocked fetches and indexes every schema definition for gv. Callers
+// must hold g.mu.
+func (g *DiscoveryOpenAPIGetter) loadLocked(gv schema.GroupVersion) error {
+	doc, err := g.root.GVSpec(gv)
+	if err != nil {
+		return fmt.Errorf("fetching OpenAPI v3 schema for %s: %v", gv, err)
+	}
+
+	if doc.Components == nil {
+		return nil
+	}
+
+	defs := doc.Components.Schemas
+
+	// Real cluster documents nest shared types (ObjectMeta, PodSpec, ...)
+	// as a bare $ref into a sibling component instead of inlining their
+	// Properties, so resolve those before indexing -- otherwise the
+	// recursive read-only strip never recurses past the top-level Kind.
+	for _, def := range defs {
+		resolveRefs(def, defs, map[string]bool{})
+	}
+
+	for _, def := range defs {
+		for _, gvk := range gvksOf(def) {
+			g.byGVK[gvk] = def
+		}
+	}
+
+	return nil
+}
+
+// resolveRefs replaces every $ref found while walking sch's Properties
+// with the (recursively resolved) schema it points to, mutating sch in
+// place. seen guards against reference cycles along the current path: it
+// holds the component names followed so far and the Properties maps being
+// walked, since an inlined component shares its Properties map with the
+// original, so a resolved cycle loops back onto a map already on the path.
+// A $ref cut short by a cycle is left in place.
+func resolveRefs(sch *spec.Schema, components map[string]*spec.Schema, seen map[string]bool) {
+	if sch == nil || sch.Properties == nil {
+		return
+	}
+
+	// keyed by the map's address, which no component name can collide with
+	walked := fmt.Sprintf("%p", sch.Properties)
+	if seen[walked] {
+		return
+	}
+	seen[walked] = true
+	defer delete(seen, walked)
+
+	for name, prop := range sch.Properties {
+		refName := refComponentName(prop.Ref)
+		if refName == "" {
+			resolveRefs(&prop, components, seen)
+			sch.Properties[name] = prop
+			continue
+		}
+
+		if seen[refName] {
+			continue
+		}
+
+		target, ok := components[refName]
+		if !ok {
+			continue
+		}
+
+		nextSeen := make(map[string]bool, len(seen)+1)
+		for k := range seen {
+			nextSeen[k] = true
+		}
+		nextSeen[refName] = true
+
+		resolveRefs(target, components, nextSeen)
+		sch.Properties[name] = *target
+	}
+}
+
+// refComponentName extracts the component name a $ref points to within
+// the same document, or "" if ref isn't a same-document component ref.
+func refComponentName(ref spec.Ref) string {
+	url := ref.String()
+	if !strings.HasPrefix(url, refComponentPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(url, refComponentPrefix)
+}
+
+// gvksOf extracts the GVK(s) a definition's x-kubernetes-group-version-kind
+// extension declares it describes.
+func gvksOf(def *spec.Schema) []schema.GroupVersionKind {
+	raw, ok := def.Extensions[gvkExtensionName]
+	if !ok {
+		return nil
+	}
+
+	entries, ok := raw.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	gvks := make([]schema.GroupVersionKind, 0, len(entries))
+	for _, e := range entries {
+		m, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		group, _ := m["group"].(string)
+		version, _ := m["version"].(string)
+		kind, _ := m["kind"].(string)
+		if kind == "" {
+			continue
+		}
+
+		gvks = append(gvks, schema.GroupVersionKind{Group: group, Version: version, Kind: kind})
+	}
+
+	return gvks
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+func TestResolveRefsInlinesReferencedComponents(t *testing.T) {
+	objectMeta := &spec.Schema{
+		SchemaProps: spec.SchemaProps{
+			Properties: map[string]spec.Schema{
+				"uid": {SchemaProps: spec.SchemaProps{ReadOnly: true}},
+			},
+		},
+	}
+
+	pod := &spec.Schema{
+		SchemaProps: spec.SchemaProps{
+			Properties: map[string]spec.Schema{
+				"metadata": {
+					SchemaProps: spec.SchemaProps{
+						Ref: spec.MustCreateRef(refComponentPrefix + "io.k8s.api.core.v1.ObjectMeta"),
+					},
+				},
+			},
+		},
+	}
+
+	components := map[string]*spec.Schema{
+		"io.k8s.api.core.v1.ObjectMeta": objectMeta,
+		"io.k8s.api.core.v1.Pod":        pod,
+	}
+
+	resolveRefs(pod, components, map[string]bool{})
+
+	md, ok := pod.Properties["metadata"]
+	if !ok {
+		t.Fatalf("expected metadata property to survive resolution")
+	}
+	if _, ok := md.Properties["uid"]; !ok {
+		t.Errorf("expected metadata.uid to be inlined from the referenced ObjectMeta component, got properties %v", md.Properties)
+	}
+}
+
+func TestResolveRefsGuardsAgainstCycles(t *testing.T) {
+	a := &spec.Schema{
+		SchemaProps: spec.SchemaProps{
+			Properties: map[string]spec.Schema{
+				"b": {SchemaProps: spec.SchemaProps{Ref: spec.MustCreateRef(refComponentPrefix + "b")}},
+			},
+		},
+	}
+	b := &spec.Schema{
+		SchemaProps: spec.SchemaProps{
+			Properties: map[string]spec.Schema{
+				"a": {SchemaProps: spec.SchemaProps{Ref: spec.MustCreateRef(refComponentPrefix + "a")}},
+			},
+		},
+	}
+
+	components := map[string]*spec.Schema{"a": a, "b": b}
+
+	// A cyclic $ref must not recurse forever; this simply must return.
+	resolveRefs(a, components, map[string]bool{})
+}
+
+func TestRefComponentName(t *testing.T) {
+	cases := []struct {
+		name string
+		ref  spec.Ref
+		want string
+	}{
+		{"same document ref", spec.MustCreateRef(refComponentPrefix + "io.k8s.api.core.v1.Pod"), "io.k8s.api.core.v1.Pod"},
+		{"empty ref", spec.Ref{}, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := refComponentName(c.ref); got != c.want {
+				t.Errorf("refComponentName() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"github.com/bpineau/katafygio/config"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// OwnerFilter decides whether an object should be skipped because it's a
+// generated child of another object we're already backing up.
+type OwnerFilter interface {
+	Skip(obj *unstructured.Unstructured) bool
+}
+
+// knownParents maps a child Kind to the parent Kinds whose controller
+// owner-reference means "this object is generated; its owner already
+// captures the desired state" -- matching how Velero and kubebuilder-style
+// controllers reason about ownership.
+var knownParents = map[string][]string{
+	"ReplicaSet":    {"Deployment"},
+	"Pod":           {"ReplicaSet", "StatefulSet", "DaemonSet", "Job"},
+	"Job":           {"CronJob"},
+	"Endpoints":     {"Service"},
+	"EndpointSlice": {"Service"},
+}
+
+// defaultOwnerFilter is the Pruner-style default OwnerFilter: it drops any
+// object carrying a controller owner-reference to a known parent Kind,
+// unless that child Kind was explicitly opted out of filtering.
+type defaultOwnerFilter struct {
+	kind    string
+	enabled bool
+	keep    map[string]bool
+}
+
+// newDefaultOwnerFilter builds the default OwnerFilter for a controller
+// watching the given Kind, honoring the KfConfig owner-filtering policy.
+func newDefaultOwnerFilter(kind string, conf *config.KfConfig) *defaultOwnerFilter {
+	keep := make(map[string]bool, len(conf.KeepOwned))
+	for _, k := range conf.KeepOwned {
+		keep[k] = true
+	}
+
+	return &defaultOwnerFilter{
+		kind:    kind,
+		enabled: !conf.DisableOwnerFilter,
+		keep:    keep,
+	}
+}
+
+// Skip implements OwnerFilter.
+func (f *defaultOwnerFilter) Skip(obj *unstructured.Unstructured) bool {
+	if !f.enabled || f.keep[f.kind] {
+		return false
+	}
+
+	parents, known := knownParents[f.kind]
+	if !known {
+		return false
+	}
+
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.Controller == nil || !*ref.Controller {
+			continue
+		}
+
+		for _, parent := range parents {
+			if ref.Kind == parent {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/bpineau/katafygio/config"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func boolPtr(b bool) *bool { return &b }
+
+func objWithOwner(ownerKind string, controller bool) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+
+	if ownerKind == "" {
+		return obj
+	}
+
+	obj.SetOwnerReferences([]metav1.OwnerReference{
+		{Kind: ownerKind, Name: "owner", Controller: boolPtr(controller)},
+	})
+
+	return obj
+}
+
+func TestDefaultOwnerFilterSkip(t *testing.T) {
+	cases := []struct {
+		name       string
+		kind       string
+		ownerKind  string
+		controller bool
+		keepOwned  []string
+		disabled   bool
+		want       bool
+	}{
+		{name: "controller owner of a known parent is skipped", kind: "Pod", ownerKind: "ReplicaSet", controller: true, want: true},
+		{name: "non-controller owner is kept", kind: "Pod", ownerKind: "ReplicaSet", controller: false, want: false},
+		{name: "no owner is kept", kind: "Pod", want: false},
+		{name: "owner of an unrelated kind is kept", kind: "Pod", ownerKind: "CustomThing", controller: true, want: false},
+		{name: "kind with no known parents is kept", kind: "Deployment", ownerKind: "SomeOperator", controller: true, want: false},
+		{name: "explicitly kept kind is never skipped", kind: "Pod", ownerKind: "ReplicaSet", controller: true, keepOwned: []string{"Pod"}, want: false},
+		{name: "filter disabled keeps everything", kind: "Pod", ownerKind: "ReplicaSet", controller: true, disabled: true, want: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := &config.KfConfig{KeepOwned: tc.keepOwned, DisableOwnerFilter: tc.disabled}
+			f := newDefaultOwnerFilter(tc.kind, conf)
+
+			got := f.Skip(objWithOwner(tc.ownerKind, tc.controller))
+			if got != tc.want {
+				t.Errorf("Skip() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
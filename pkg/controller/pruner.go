@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+// Pruner strips fields from an object before it's serialized for backup, so
+// the resulting YAML round-trips cleanly through `kubectl apply` instead of
+// carrying server-populated noise that churns the git history on every run.
+type Pruner interface {
+	Prune(obj *unstructured.Unstructured)
+}
+
+// MultiPruner runs a sequence of Pruner in order, so a Kind can combine the
+// default schema-driven pruning with one or more Kind-specific overrides.
+type MultiPruner []Pruner
+
+// Prune implements Pruner.
+func (m MultiPruner) Prune(obj *unstructured.Unstructured) {
+	for _, p := range m {
+		p.Prune(obj)
+	}
+}
+
+// FieldPruner unconditionally drops a fixed set of dot-separated paths
+// (eg. "spec.clusterIP", "spec.finalizers"), for the cases a generic,
+// schema-driven prune can't express: fields the server accepts and the
+// schema doesn't mark read-only, but that still shouldn't round-trip
+// through git (a Service's assigned clusterIP, a Namespace's finalizers).
+type FieldPruner []string
+
+// Prune implements Pruner.
+func (f FieldPruner) Prune(obj *unstructured.Unstructured) {
+	for _, path := range f {
+		unstructured.RemoveNestedField(obj.Object, splitPath(path)...)
+	}
+}
+
+func splitPath(path string) []string {
+	var fields []string
+	start := 0
+	for i := 0; i < len(path); i++ {
+		if path[i] == '.' {
+			fields = append(fields, path[start:i])
+			start = i + 1
+		}
+	}
+	return append(fields, path[start:])
+}
+
+// commonServerFields are populated by the apiserver on every Kind, and
+// carry no useful signal once an object has been read back out.
+var commonServerFields = []string{"selfLink", "uid", "resourceVersion", "generation", "managedFields", "creationTimestamp"}
+
+// SchemaGetter resolves the discovered OpenAPI v3 schema for a GVK. It
+// returns an error (or a nil schema) when the GVK hasn't been discovered
+// yet, in which case SchemaPruner falls back to stripping commonServerFields.
+type SchemaGetter interface {
+	SchemaFor(gvk schema.GroupVersionKind) (*spec.Schema, error)
+}
+
+// SchemaPruner is the default Pruner: it consults the discovered OpenAPI v3
+// schema for each object's GVK and strips every field marked read-only or
+// whose value equals its schema default, plus the status subresource when
+// the schema declares one.
+type SchemaPruner struct {
+	schemas SchemaGetter
+}
+
+// NewSchemaPruner returns a Pruner backed by the given OpenAPI schema
+// source. A nil SchemaGetter is valid: every object then falls back to the
+// commonServerFields-only prune.
+func NewSchemaPruner(schemas SchemaGetter) *SchemaPruner {
+	return &SchemaPruner{schemas: schemas}
+}
+
+// Prune implements Pruner.
+func (p *SchemaPruner) Prune(obj *unstructured.Unstructured) {
+	uc := obj.UnstructuredContent()
+
+	var sch *spec.Schema
+	if p.schemas != nil {
+		sch, _ = p.schemas.SchemaFor(obj.GroupVersionKind())
+	}
+
+	if sch == nil {
+		pruneCommonFields(uc)
+		return
+	}
+
+	pruneReadOnly(uc, sch)
+}
+
+func pruneCommonFields(uc map[string]interface{}) {
+	delete(uc, "status")
+
+	md, ok := uc["metadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for _, f := range commonServerFields {
+		delete(md, f)
+	}
+}
+
+// pruneReadOnly walks obj alongside its schema, dropping any property
+// that's read-only or set to its default, recursing into nested objects, and
+// dropping "status" outright when the schema marks it a subresource.
+func pruneReadOnly(obj map[string]interface{}, sch *spec.Schema) {
+	for name, prop := range sch.Properties {
+		val, exists := obj[name]
+		if !exists {
+			continue
+		}
+
+		if prop.ReadOnly || matchesDefault(val, prop.Default) {
+			delete(obj, name)
+			continue
+		}
+
+		if sub, ok := val.(map[string]interface{}); ok {
+			propCopy := prop
+			pruneReadOnly(sub, &propCopy)
+		}
+	}
+
+	if isSubresource(sch.Properties["status"]) {
+		delete(obj, "status")
+	}
+}
+
+func isSubresource(prop spec.Schema) bool {
+	v, ok := prop.Extensions.GetBool("x-kubernetes-subresource")
+	return ok && v
+}
+
+// matchesDefault reports whether an object's actual value for a field is
+// equal to the schema's declared default for it. Only a value that matches
+// its default is safe to drop: the field wasn't explicitly set away from
+// it, so applying the backup without it reproduces the same object. A
+// schema default alone doesn't mean the value can be dropped -- eg. a
+// Deployment with an explicit "replicas: 3" (schema default 1) must keep
+// "replicas", or restoring the backup would silently revert it to 1.
+func matchesDefault(val, def interface{}) bool {
+	if def == nil {
+		return false
+	}
+
+	if reflect.DeepEqual(val, def) {
+		return true
+	}
+
+	// unstructured content decodes JSON numbers as int64/float64, while a
+	// schema's default (parsed straight from the OpenAPI document) may be
+	// float64 -- compare numerically before giving up.
+	vf, vok := toFloat64(val)
+	df, dok := toFloat64(def)
+	return vok && dok && vf == df
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+type fakeSchemaGetter map[schema.GroupVersionKind]*spec.Schema
+
+func (f fakeSchemaGetter) SchemaFor(gvk schema.GroupVersionKind) (*spec.Schema, error) {
+	return f[gvk], nil
+}
+
+func TestSchemaPrunerFallsBackWithoutASchema(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name":              "foo",
+			"uid":               "123",
+			"resourceVersion":   "456",
+			"generation":        int64(1),
+			"managedFields":     []interface{}{"whatever"},
+			"creationTimestamp": "2020-01-01T00:00:00Z",
+		},
+		"status": map[string]interface{}{"phase": "Running"},
+	}}
+
+	NewSchemaPruner(nil).Prune(obj)
+
+	md := obj.Object["metadata"].(map[string]interface{})
+	for _, f := range []string{"uid", "resourceVersion", "generation", "managedFields", "creationTimestamp"} {
+		if _, ok := md[f]; ok {
+			t.Errorf("expected metadata.%s to be pruned", f)
+		}
+	}
+	if _, ok := obj.Object["status"]; ok {
+		t.Errorf("expected status to be pruned")
+	}
+	if md["name"] != "foo" {
+		t.Errorf("expected metadata.name to survive pruning, got %v", md["name"])
+	}
+}
+
+func TestSchemaPrunerUsesTheDiscoveredSchema(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"}
+
+	sch := &spec.Schema{
+		SchemaProps: spec.SchemaProps{
+			Properties: map[string]spec.Schema{
+				"status": {
+					SchemaProps: spec.SchemaProps{
+						Properties: map[string]spec.Schema{},
+					},
+					VendorExtensible: spec.VendorExtensible{
+						Extensions: spec.Extensions{"x-kubernetes-subresource": true},
+					},
+				},
+				"spec": {
+					SchemaProps: spec.SchemaProps{
+						Properties: map[string]spec.Schema{
+							"nodeName":        {SchemaProps: spec.SchemaProps{ReadOnly: true}},
+							"replicas":        {SchemaProps: spec.SchemaProps{Default: float64(1)}},
+							"minReadySeconds": {SchemaProps: spec.SchemaProps{Default: float64(0)}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"spec": map[string]interface{}{
+			"nodeName":        "node-1",
+			"replicas":        int64(3),
+			"minReadySeconds": int64(0),
+			"keepme":          "yes",
+		},
+		"status": map[string]interface{}{"phase": "Running"},
+	}}
+
+	NewSchemaPruner(fakeSchemaGetter{gvk: sch}).Prune(obj)
+
+	spc := obj.Object["spec"].(map[string]interface{})
+	if _, ok := spc["nodeName"]; ok {
+		t.Errorf("expected spec.nodeName (readOnly) to be pruned")
+	}
+	if _, ok := spc["minReadySeconds"]; ok {
+		t.Errorf("expected spec.minReadySeconds (value equals default) to be pruned")
+	}
+	if spc["replicas"] != int64(3) {
+		t.Errorf("expected spec.replicas (value differs from default) to survive pruning, got %v", spc["replicas"])
+	}
+	if spc["keepme"] != "yes" {
+		t.Errorf("expected spec.keepme to survive pruning, got %v", spc["keepme"])
+	}
+	if _, ok := obj.Object["status"]; ok {
+		t.Errorf("expected status (subresource) to be pruned")
+	}
+}
+
+func TestFieldPruner(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"clusterIP": "10.0.0.1",
+			"selector":  map[string]interface{}{"app": "foo"},
+		},
+	}}
+
+	FieldPruner{"spec.clusterIP"}.Prune(obj)
+
+	spc := obj.Object["spec"].(map[string]interface{})
+	if _, ok := spc["clusterIP"]; ok {
+		t.Errorf("expected spec.clusterIP to be pruned")
+	}
+	if spc["selector"] == nil {
+		t.Errorf("expected spec.selector to survive pruning")
+	}
+}
@@ -0,0 +1,309 @@
+// Package discovery watches CustomResourceDefinition objects and keeps a
+// controller.Controller running for each of their served versions, so that
+// custom resources (eg. Karmada policies, Argo Applications, cert-manager
+// Certificates) get backed up as soon as their CRD is installed, without
+// having to restart katafygio or list kinds statically.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bpineau/katafygio/config"
+	"github.com/bpineau/katafygio/pkg/controller"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	discoveryclient "k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// reconcileKey is the single, static workqueue key used to coalesce CRD
+// add/update/delete notifications into one reconcile pass.
+const reconcileKey = "reconcile"
+
+// crdController bundles a running controller.Controller with the GVR it
+// was started for, so we can stop it cleanly when its CRD disappears.
+type crdController struct {
+	gvr  schema.GroupVersionResource
+	ctrl *controller.Controller
+}
+
+// CrdDiscovery watches CustomResourceDefinition objects and reconciles the
+// desired set of per-GVR controllers against the ones actually running.
+type CrdDiscovery struct {
+	client    apiextensionsclientset.Interface
+	dynClient dynamic.Interface
+	evchan    chan controller.Event
+	config    *config.KfConfig
+
+	// schemas drives schema-based field pruning for every controller this
+	// discovery loop spawns: it's shared across them, so a GroupVersion's
+	// OpenAPI document is only ever fetched once.
+	schemas *controller.DiscoveryOpenAPIGetter
+
+	informer cache.SharedIndexInformer
+	queue    workqueue.TypedRateLimitingInterface[string]
+
+	// cancel defaults to a no-op in New, so Stop is always safe to call
+	// even on a CrdDiscovery that was never Start()ed (eg. an error path).
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[schema.GroupVersionResource]*crdController
+}
+
+// New returns a CrdDiscovery, ready to be Start()ed. discover is used to
+// fetch the cluster's OpenAPI v3 document, so discovered CRDs get the same
+// schema-driven field pruning as the statically configured controllers.
+func New(client apiextensionsclientset.Interface, dynClient dynamic.Interface, discover discoveryclient.DiscoveryInterface, evchan chan controller.Event, conf *config.KfConfig) *CrdDiscovery {
+	queue := workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]())
+
+	lw := &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			return client.ApiextensionsV1().CustomResourceDefinitions().List(context.Background(), opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			return client.ApiextensionsV1().CustomResourceDefinitions().Watch(context.Background(), opts)
+		},
+	}
+
+	informer := cache.NewSharedIndexInformer(
+		lw,
+		&apiextensionsv1.CustomResourceDefinition{},
+		conf.ResyncIntv,
+		cache.Indexers{},
+	)
+
+	d := &CrdDiscovery{
+		cancel:    func() {},
+		client:    client,
+		dynClient: dynClient,
+		evchan:    evchan,
+		config:    conf,
+		schemas:   controller.NewDiscoveryOpenAPIGetter(discover.OpenAPIV3()),
+		informer:  informer,
+		queue:     queue,
+		running:   make(map[schema.GroupVersionResource]*crdController),
+	}
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { queue.Add(reconcileKey) },
+		UpdateFunc: func(old, new interface{}) { queue.Add(reconcileKey) },
+		DeleteFunc: func(obj interface{}) { queue.Add(reconcileKey) },
+	})
+
+	return d
+}
+
+// Start launches the CRD discovery loop in the background, spawning and
+// tearing down per-GVR controllers as CRDs come and go.
+func (d *CrdDiscovery) Start(ctx context.Context) {
+	logger := klog.FromContext(ctx)
+	logger.Info("Starting CRD discovery")
+	defer utilruntime.HandleCrash()
+
+	ctx, d.cancel = context.WithCancel(ctx)
+
+	go d.informer.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), d.informer.HasSynced) {
+		utilruntime.HandleError(fmt.Errorf("timed out waiting for CRD discovery cache to sync"))
+		return
+	}
+
+	// trigger an initial reconcile, so CRDs installed before we started are picked up
+	d.queue.Add(reconcileKey)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		wait.UntilWithContext(ctx, d.runWorker, time.Second)
+	}()
+}
+
+// Stop halts the discovery loop and every controller it spawned.
+func (d *CrdDiscovery) Stop(ctx context.Context) {
+	d.cancel()
+	d.queue.ShutDown()
+	d.wg.Wait()
+
+	d.mu.Lock()
+	for gvr, rc := range d.running {
+		rc.ctrl.Stop(ctx)
+		delete(d.running, gvr)
+	}
+	d.mu.Unlock()
+
+	klog.FromContext(ctx).Info("Stopped CRD discovery")
+}
+
+func (d *CrdDiscovery) runWorker(ctx context.Context) {
+	for d.processNextItem(ctx) {
+		// continue looping
+	}
+}
+
+func (d *CrdDiscovery) processNextItem(ctx context.Context) bool {
+	key, quit := d.queue.Get()
+	if quit {
+		return false
+	}
+	defer d.queue.Done(key)
+
+	if err := d.reconcile(ctx); err != nil {
+		klog.FromContext(ctx).Error(err, "error reconciling CRD controllers, will retry")
+		d.queue.AddRateLimited(key)
+		return true
+	}
+
+	d.queue.Forget(key)
+	return true
+}
+
+// reconcile computes the desired set of per-GVR controllers from the
+// currently known CRDs, then starts missing ones and stops extraneous ones.
+func (d *CrdDiscovery) reconcile(ctx context.Context) error {
+	crds := make([]*apiextensionsv1.CustomResourceDefinition, 0, len(d.informer.GetStore().List()))
+	for _, obj := range d.informer.GetStore().List() {
+		crd, ok := obj.(*apiextensionsv1.CustomResourceDefinition)
+		if !ok {
+			continue
+		}
+		crds = append(crds, crd)
+	}
+
+	desired := desiredFromCRDs(crds, d.isAllowed)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	toStart, toStop := diffControllers(desired, d.running)
+
+	for _, gvr := range toStop {
+		d.running[gvr].ctrl.Stop(ctx)
+		delete(d.running, gvr)
+	}
+
+	for gvr, info := range toStart {
+		ctrl := controller.New(ctx, d.listerWatcherFor(gvr), d.evchan, info.kind, d.config)
+		ctrl.SetPruner(controller.NewSchemaPruner(d.schemas))
+		ctrl.Start(ctx)
+		d.running[gvr] = &crdController{gvr: gvr, ctrl: ctrl}
+	}
+
+	return nil
+}
+
+// desiredFromCRDs computes the set of GVRs that should have a running
+// controller from the currently known CRDs: every served version of every
+// CRD whose Kind passes allowed.
+func desiredFromCRDs(crds []*apiextensionsv1.CustomResourceDefinition, allowed func(kind string) bool) map[schema.GroupVersionResource]crdVersionInfo {
+	desired := make(map[schema.GroupVersionResource]crdVersionInfo)
+
+	for _, crd := range crds {
+		if !allowed(crd.Spec.Names.Kind) {
+			continue
+		}
+
+		for _, ver := range crd.Spec.Versions {
+			if !ver.Served {
+				continue
+			}
+
+			gvr := schema.GroupVersionResource{
+				Group:    crd.Spec.Group,
+				Version:  ver.Name,
+				Resource: crd.Spec.Names.Plural,
+			}
+
+			desired[gvr] = crdVersionInfo{kind: crd.Spec.Names.Kind}
+		}
+	}
+
+	return desired
+}
+
+// diffControllers compares the desired set of GVRs against the ones
+// currently running, returning the GVRs that need a new controller started
+// (with the crdVersionInfo to start it with) and the GVRs whose controller
+// needs to be stopped.
+func diffControllers(desired map[schema.GroupVersionResource]crdVersionInfo, running map[schema.GroupVersionResource]*crdController) (map[schema.GroupVersionResource]crdVersionInfo, []schema.GroupVersionResource) {
+	toStart := make(map[schema.GroupVersionResource]crdVersionInfo)
+	var toStop []schema.GroupVersionResource
+
+	for gvr := range running {
+		if _, ok := desired[gvr]; !ok {
+			toStop = append(toStop, gvr)
+		}
+	}
+
+	for gvr, info := range desired {
+		if _, ok := running[gvr]; !ok {
+			toStart[gvr] = info
+		}
+	}
+
+	return toStart, toStop
+}
+
+// crdVersionInfo is the subset of a CRD version's properties we need to
+// build a ListerWatcher and name its controller.
+type crdVersionInfo struct {
+	kind string
+}
+
+// listerWatcherFor returns a generic, unstructured ListerWatcher for the
+// given GVR, the same kind of ListerWatcher used by the statically
+// configured controllers. NamespaceableResourceInterface lists/watches
+// across all namespaces when used directly (without narrowing via
+// .Namespace()), which is what we want for a cluster-wide backup.
+func (d *CrdDiscovery) listerWatcherFor(gvr schema.GroupVersionResource) cache.ListerWatcher {
+	var resource dynamic.NamespaceableResourceInterface = d.dynClient.Resource(gvr)
+
+	return &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			return resource.List(context.Background(), opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			return resource.Watch(context.Background(), opts)
+		},
+	}
+}
+
+// isAllowed applies the same include/exclude kind filters the statically
+// configured controllers use, so discovered CRDs obey the same policy.
+func (d *CrdDiscovery) isAllowed(kind string) bool {
+	kind = strings.ToLower(kind)
+
+	for _, excluded := range d.config.Exclude {
+		if strings.ToLower(excluded) == kind {
+			return false
+		}
+	}
+
+	if len(d.config.Include) == 0 {
+		return true
+	}
+
+	for _, included := range d.config.Include {
+		if strings.ToLower(included) == kind {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,122 @@
+package discovery
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/bpineau/katafygio/config"
+	"github.com/bpineau/katafygio/pkg/controller"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func crd(group, plural, kind string, served ...string) *apiextensionsv1.CustomResourceDefinition {
+	c := &apiextensionsv1.CustomResourceDefinition{}
+	c.Spec.Group = group
+	c.Spec.Names.Plural = plural
+	c.Spec.Names.Kind = kind
+
+	for _, v := range served {
+		c.Spec.Versions = append(c.Spec.Versions, apiextensionsv1.CustomResourceDefinitionVersion{
+			Name: v, Served: true,
+		})
+	}
+
+	return c
+}
+
+func TestDesiredFromCRDs(t *testing.T) {
+	crds := []*apiextensionsv1.CustomResourceDefinition{
+		crd("example.com", "widgets", "Widget", "v1", "v1beta1"),
+		crd("example.com", "gadgets", "Gadget", "v1"),
+	}
+
+	allowAll := func(string) bool { return true }
+
+	got := desiredFromCRDs(crds, allowAll)
+
+	want := map[schema.GroupVersionResource]crdVersionInfo{
+		{Group: "example.com", Version: "v1", Resource: "widgets"}:      {kind: "Widget"},
+		{Group: "example.com", Version: "v1beta1", Resource: "widgets"}: {kind: "Widget"},
+		{Group: "example.com", Version: "v1", Resource: "gadgets"}:      {kind: "Gadget"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("desiredFromCRDs() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDesiredFromCRDsSkipsDisallowedKindsAndUnservedVersions(t *testing.T) {
+	c := crd("example.com", "widgets", "Widget", "v1")
+	c.Spec.Versions = append(c.Spec.Versions, apiextensionsv1.CustomResourceDefinitionVersion{
+		Name: "v1alpha1", Served: false,
+	})
+
+	denyAll := func(string) bool { return false }
+	if got := desiredFromCRDs([]*apiextensionsv1.CustomResourceDefinition{c}, denyAll); len(got) != 0 {
+		t.Errorf("expected no desired GVRs for a disallowed Kind, got %+v", got)
+	}
+
+	allowAll := func(string) bool { return true }
+	got := desiredFromCRDs([]*apiextensionsv1.CustomResourceDefinition{c}, allowAll)
+	if _, ok := got[schema.GroupVersionResource{Group: "example.com", Version: "v1alpha1", Resource: "widgets"}]; ok {
+		t.Errorf("expected unserved version v1alpha1 to be skipped, got %+v", got)
+	}
+	if len(got) != 1 {
+		t.Errorf("expected exactly one desired GVR, got %+v", got)
+	}
+}
+
+func TestDiffControllers(t *testing.T) {
+	widget := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	gadget := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "gadgets"}
+	gizmo := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "gizmos"}
+
+	desired := map[schema.GroupVersionResource]crdVersionInfo{
+		widget: {kind: "Widget"},
+		gizmo:  {kind: "Gizmo"},
+	}
+
+	running := map[schema.GroupVersionResource]*crdController{
+		widget: {gvr: widget, ctrl: &controller.Controller{}},
+		gadget: {gvr: gadget, ctrl: &controller.Controller{}},
+	}
+
+	toStart, toStop := diffControllers(desired, running)
+
+	if !reflect.DeepEqual(toStart, map[schema.GroupVersionResource]crdVersionInfo{gizmo: {kind: "Gizmo"}}) {
+		t.Errorf("expected only gizmo to start, got %+v", toStart)
+	}
+
+	sort.Slice(toStop, func(i, j int) bool { return toStop[i].Resource < toStop[j].Resource })
+	if !reflect.DeepEqual(toStop, []schema.GroupVersionResource{gadget}) {
+		t.Errorf("expected only gadget to stop, got %+v", toStop)
+	}
+}
+
+func TestIsAllowed(t *testing.T) {
+	cases := []struct {
+		name    string
+		include []string
+		exclude []string
+		kind    string
+		want    bool
+	}{
+		{"no filters allows everything", nil, nil, "Widget", true},
+		{"include restricts to listed kinds", []string{"Widget"}, nil, "Gadget", false},
+		{"include allows a listed kind, case-insensitively", []string{"widget"}, nil, "Widget", true},
+		{"exclude drops a listed kind even with no include", nil, []string{"Gadget"}, "Gadget", false},
+		{"exclude wins over include", []string{"Gadget"}, []string{"Gadget"}, "Gadget", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := &CrdDiscovery{config: &config.KfConfig{Include: c.include, Exclude: c.exclude}}
+			if got := d.isAllowed(c.kind); got != c.want {
+				t.Errorf("isAllowed(%q) = %v, want %v", c.kind, got, c.want)
+			}
+		})
+	}
+}